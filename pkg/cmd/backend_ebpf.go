@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"ksniff/kube"
+)
+
+const ebpfLocalPath = "/ksniff-bpf-loader"
+const ebpfRemotePath = "/tmp/ksniff-bpf"
+const ebpfRequiredCapability = "cap_bpf"
+
+// selectBackend resolves the backend actually used for this run. The ebpf
+// backend needs CAP_BPF (or CAP_SYS_ADMIN on pre-5.8 kernels) inside the
+// target container; when that's missing we fall back to tcpdump rather
+// than failing the capture outright.
+func (o *SniffOptions) selectBackend() string {
+	if o.userSpecifiedBackend != BackendEbpf {
+		return BackendTcpdump
+	}
+
+	if !o.ebpfSupported() {
+		log.Warn("target container doesn't support eBPF capture (missing CAP_BPF or blocked syscalls), falling back to tcpdump")
+		return BackendTcpdump
+	}
+
+	return BackendEbpf
+}
+
+func (o *SniffOptions) ebpfSupported() bool {
+	stdOut := new(kube.Writer)
+	stdErr := new(kube.Writer)
+
+	checkCmd := "grep -qi " + ebpfRequiredCapability + " /proc/self/status 2>/dev/null && echo yes || " +
+		"(capsh --print 2>/dev/null | grep -qiE 'bpf|sys_admin' && echo yes)"
+
+	exitCode, err := o.runner.Run([]string{"/bin/sh", "-c", checkCmd}, nil, stdOut, stdErr)
+	if err != nil || exitCode != 0 {
+		return false
+	}
+
+	return strings.TrimSpace(stdOut.Output) == "yes"
+}
+
+func (o *SniffOptions) uploadEbpfLoaderIfMissing() error {
+	log.Infof("checking for ksniff eBPF loader on: %s", ebpfRemotePath)
+
+	isExist, err := CheckIfTcpdumpExistOnPod(o, ebpfRemotePath)
+	if err != nil {
+		return err
+	}
+
+	if isExist {
+		log.Info("eBPF loader was already on remote pod")
+		return nil
+	}
+
+	log.Infof("couldn't find eBPF loader on: %s, starting to upload", ebpfRemotePath)
+
+	if err := o.runner.CopyFile(o.userSpecifiedLocalEbpfPath, ebpfRemotePath); err != nil {
+		return err
+	}
+
+	log.Info("eBPF loader uploaded successfully")
+
+	return nil
+}
+
+// compileFilter turns the user-supplied --filter expression into raw
+// struct sock_filter instructions on the client, the same way `tcpdump -d`
+// would, so the loader on the target never needs libpcap or bpf tooling of
+// its own, just the ability to load a pre-compiled program.
+func (o *SniffOptions) compileFilter() (string, error) {
+	if o.userSpecifiedFilter == "" {
+		return "", nil
+	}
+
+	instructions, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, 65535, o.userSpecifiedFilter)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to compile filter '%s'", o.userSpecifiedFilter)
+	}
+
+	raw := make([]byte, len(instructions)*8)
+
+	for i, insn := range instructions {
+		binary.LittleEndian.PutUint16(raw[i*8:], insn.Code)
+		raw[i*8+2] = insn.Jt
+		raw[i*8+3] = insn.Jf
+		binary.LittleEndian.PutUint32(raw[i*8+4:], insn.K)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+func (o *SniffOptions) executeEbpfOnRemotePod(stdOut io.Writer) {
+	stdErr := new(kube.NopWriter)
+
+	filterBytecode, err := o.compileFilter()
+	if err != nil {
+		log.Errorf("failed to compile filter for eBPF backend: %v", err)
+		return
+	}
+
+	cmd := []string{ebpfRemotePath, "-iface", "eth0"}
+	if filterBytecode != "" {
+		cmd = append(cmd, "-filter-bytecode", filterBytecode)
+	}
+
+	o.runner.Run(cmd, nil, stdOut, stdErr)
+}
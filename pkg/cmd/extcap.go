@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"ksniff/kube"
+)
+
+// extcapInterfacePrefix namespaces ksniff's interfaces inside Wireshark's
+// shared extcap interface list, so they don't collide with interfaces
+// offered by other extcap-capable tools.
+const extcapInterfacePrefix = "ksniff"
+
+// IsExtcapMode reports whether the process was invoked by Wireshark as an
+// extcap utility rather than as a normal kubectl-sniff run. Wireshark drives
+// extcap tools through a sequence of these flags instead of arguments, so
+// this has to be checked before Complete/Validate touch the positional pod
+// argument at all.
+func (o *SniffOptions) IsExtcapMode() bool {
+	return o.extcapInterfaces || o.extcapDlts || o.extcapConfig || o.extcapCapture
+}
+
+func extcapInterfaceID(namespace, pod, container string) string {
+	return fmt.Sprintf("%s:%s/%s/%s", extcapInterfacePrefix, namespace, pod, container)
+}
+
+func parseExtcapInterfaceID(id string) (namespace, pod, container string, err error) {
+	prefix := extcapInterfacePrefix + ":"
+
+	if !strings.HasPrefix(id, prefix) {
+		return "", "", "", errors.Errorf("not a ksniff extcap interface: '%s'", id)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(id, prefix), "/")
+	if len(parts) != 3 {
+		return "", "", "", errors.Errorf("malformed ksniff extcap interface: '%s'", id)
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+// RunExtcap implements the subset of the Wireshark extcap protocol ksniff
+// needs: interface discovery, DLT/config reporting, and driving a capture
+// into the FIFO Wireshark hands us. Installing a symlink to this binary
+// under ~/.config/wireshark/extcap/ makes every reachable pod show up as a
+// capture source in Wireshark's own start screen, which is the workflow
+// long-running desktop analysts expect instead of ksniff forking wireshark.
+func (o *SniffOptions) RunExtcap(cmd *cobra.Command) error {
+	restConfig, err := o.configFlags.ToRESTConfig()
+	if err != nil {
+		log.Warnf("failed to load kubeconfig, continuing in extcap mode without cluster access: %v", err)
+	} else {
+		o.restConfig = restConfig
+	}
+
+	if o.extcapInterfaces {
+		return o.printExtcapInterfaces()
+	}
+
+	if o.extcapDlts {
+		return o.printExtcapDlts()
+	}
+
+	if o.extcapConfig {
+		return o.printExtcapConfig()
+	}
+
+	return o.runExtcapCapture()
+}
+
+func (o *SniffOptions) printExtcapInterfaces() error {
+	fmt.Println("extcap {version=1.0}{help=https://github.com/eldadru/ksniff}")
+
+	if o.restConfig == nil {
+		return nil
+	}
+
+	clientset, err := kubernetes.NewForConfig(o.restConfig)
+	if err != nil {
+		return err
+	}
+
+	namespace := o.userSpecifiedNamespace
+	if namespace == "" {
+		namespace = corev1.NamespaceAll
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: o.userSpecifiedSelector})
+	if err != nil {
+		return err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		for _, container := range pod.Spec.Containers {
+			id := extcapInterfaceID(pod.Namespace, pod.Name, container.Name)
+			fmt.Printf("interface {value=%s}{display=%s/%s (%s)}\n", id, pod.Namespace, pod.Name, container.Name)
+		}
+	}
+
+	return nil
+}
+
+func (o *SniffOptions) printExtcapDlts() error {
+	if o.extcapInterface == "" {
+		return errors.New("--extcap-interface is required with --extcap-dlts")
+	}
+
+	fmt.Println("dlt {number=1}{name=EN10MB}{display=Ethernet}")
+
+	return nil
+}
+
+func (o *SniffOptions) printExtcapConfig() error {
+	fmt.Println("arg {number=0}{call=--filter}{display=Capture filter}{type=string}{tooltip=tcpdump style filter expression}")
+	fmt.Println("arg {number=1}{call=--container}{display=Container}{type=string}{tooltip=container name, defaults to the container of the selected interface}")
+	fmt.Println("arg {number=2}{call=--remote_tcpdump_path}{display=Remote tcpdump path}{type=string}{default=" + tcpdumpRemotePath + "}")
+	fmt.Println("arg {number=3}{call=--namespace}{display=Namespace}{type=string}{default=default}{tooltip=namespace to list capture interfaces from, or leave at default then narrow with the label selector below}")
+	fmt.Println("arg {number=4}{call=--selector}{display=Label selector}{type=string}{tooltip=restrict listed interfaces to pods matching this label selector (optional)}")
+
+	return nil
+}
+
+func (o *SniffOptions) runExtcapCapture() error {
+	if o.extcapInterface == "" {
+		return errors.New("--capture requires --extcap-interface")
+	}
+
+	if o.extcapFifo == "" {
+		return errors.New("--capture requires --fifo")
+	}
+
+	namespace, pod, container, err := parseExtcapInterfaceID(o.extcapInterface)
+	if err != nil {
+		return err
+	}
+
+	o.userSpecifiedNamespace = namespace
+	o.userSpecifiedPod = pod
+	if o.userSpecifiedContainer == "" {
+		o.userSpecifiedContainer = container
+	}
+
+	if o.restConfig == nil {
+		return errors.New("no usable kubeconfig found, cannot start capture")
+	}
+
+	var kubeErr error
+	o.rawConfig, kubeErr = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+	if kubeErr != nil {
+		return kubeErr
+	}
+
+	o.restConfig.Timeout = 30 * time.Second
+
+	o.clientset, err = kubernetes.NewForConfig(o.restConfig)
+	if err != nil {
+		return err
+	}
+
+	o.runner = NewKubeRunner(o)
+
+	if err := o.UploadTcpdumpIfMissing(); err != nil {
+		return err
+	}
+
+	fifo, err := kube.OpenFifoForWrite(o.extcapFifo)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open fifo '%s'", o.extcapFifo)
+	}
+	defer fifo.Close()
+
+	o.ExecuteTcpdumpOnRemotePod(fifo)
+
+	return nil
+}
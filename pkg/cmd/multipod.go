@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// podTarget identifies a single pod+container selected by --selector.
+type podTarget struct {
+	pod       string
+	container string
+	node      string
+}
+
+func (t podTarget) interfaceName() string {
+	return fmt.Sprintf("pod=%s container=%s node=%s", t.pod, t.container, t.node)
+}
+
+func (o *SniffOptions) containerForPod(pod *corev1.Pod) string {
+	if o.userSpecifiedContainer != "" {
+		return o.userSpecifiedContainer
+	}
+
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+
+	return ""
+}
+
+func (o *SniffOptions) resolvePodTargets() ([]podTarget, error) {
+	pods, err := o.clientset.CoreV1().Pods(o.userSpecifiedNamespace).List(metav1.ListOptions{
+		LabelSelector: o.userSpecifiedSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []podTarget
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		targets = append(targets, podTarget{pod: pod.Name, container: o.containerForPod(pod), node: pod.Spec.NodeName})
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.Errorf("no running pods matched selector '%s'", o.userSpecifiedSelector)
+	}
+
+	return targets, nil
+}
+
+// targetOptions returns a shallow copy of o scoped to a single selector
+// target, with its own Runner built via NewRunner against that pod and
+// container. Reusing NewRunner here, rather than talking to the target
+// over a hand-built kube.ExecCommandRequest, means --runner/--backend behave the same way under --selector as they
+// do for a single named pod instead of being silently ignored.
+func (o *SniffOptions) targetOptions(t podTarget) (*SniffOptions, error) {
+	targetOpts := *o
+	targetOpts.userSpecifiedPod = t.pod
+	targetOpts.userSpecifiedContainer = t.container
+
+	runner, err := NewRunner(o.userSpecifiedRunnerType, &targetOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	targetOpts.runner = runner
+
+	return &targetOpts, nil
+}
+
+// mergedCapture fans tcpdump out across every selected pod and merges the
+// resulting pcap streams into a single pcapng file, emitting one Interface
+// Description Block per pod+container so Wireshark shows each source as
+// its own capture interface.
+type mergedCapture struct {
+	writer *pcapgo.NgWriter
+	mu     sync.Mutex
+	ifaces map[string]int
+	wg     sync.WaitGroup
+	stops  map[string]func()
+}
+
+func newMergedCapture(out io.Writer) (*mergedCapture, error) {
+	writer, err := pcapgo.NewNgWriter(out, gopacket.LinkTypeEthernet)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize pcapng writer")
+	}
+
+	return &mergedCapture{writer: writer, ifaces: make(map[string]int), stops: make(map[string]func())}, nil
+}
+
+// errCaptureStopped marks a pipe closed deliberately by stop(), as opposed
+// to the remote exec/capture ending on its own, so capture's read loop can
+// return quietly instead of logging it as a failure.
+var errCaptureStopped = errors.New("capture stopped")
+
+// stop cancels the in-flight capture for pod, if one is running, used by
+// watchPodChurn when a matching pod is deleted so its stream doesn't keep
+// running (and, for --runner node, so its helper pod doesn't keep leaking)
+// after the pod it was reading from is already gone.
+func (m *mergedCapture) stop(pod string) {
+	m.mu.Lock()
+	cancel, exists := m.stops[pod]
+	delete(m.stops, pod)
+	m.mu.Unlock()
+
+	if exists {
+		cancel()
+	}
+}
+
+func (m *mergedCapture) interfaceIndexFor(t podTarget) (int, error) {
+	name := t.interfaceName()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if idx, exists := m.ifaces[name]; exists {
+		return idx, nil
+	}
+
+	idx, err := m.writer.AddInterface(pcapgo.NgInterface{
+		Name:     name,
+		LinkType: gopacket.LinkTypeEthernet,
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to register interface for %s", name)
+	}
+
+	m.ifaces[name] = idx
+
+	return idx, nil
+}
+
+// capture starts streaming tcpdump output from a single target into the
+// merged pcapng file. It's safe to call concurrently and can be called
+// after the initial batch if a pod matching the selector shows up later.
+// The target's Runner is closed and its stop func removed once the stream
+// ends, whether that's because the remote side closed it or because
+// watchPodChurn called stop() after the pod was deleted.
+func (m *mergedCapture) capture(o *SniffOptions, t podTarget) {
+	m.wg.Add(1)
+
+	go func() {
+		defer m.wg.Done()
+
+		targetOpts, err := o.targetOptions(t)
+		if err != nil {
+			log.Errorf("failed to build runner for %s: %v", t.interfaceName(), err)
+			return
+		}
+		defer func() {
+			if err := targetOpts.runner.Close(); err != nil {
+				log.Errorf("failed to clean up runner for %s: %v", t.interfaceName(), err)
+			}
+		}()
+
+		idx, err := m.interfaceIndexFor(t)
+		if err != nil {
+			log.Errorf("failed to capture from %s: %v", t.interfaceName(), err)
+			return
+		}
+
+		if err := targetOpts.UploadTcpdumpIfMissing(); err != nil {
+			log.Errorf("failed to prepare tcpdump on %s: %v", t.interfaceName(), err)
+			return
+		}
+
+		pipeReader, pipeWriter := io.Pipe()
+
+		m.mu.Lock()
+		m.stops[t.pod] = func() { pipeWriter.CloseWithError(errCaptureStopped) }
+		m.mu.Unlock()
+
+		defer func() {
+			m.mu.Lock()
+			delete(m.stops, t.pod)
+			m.mu.Unlock()
+		}()
+
+		go func() {
+			defer pipeWriter.Close()
+			targetOpts.ExecuteTcpdumpOnRemotePod(pipeWriter)
+		}()
+
+		pcapReader, err := pcapgo.NewReader(pipeReader)
+		if err != nil {
+			log.Errorf("failed to read pcap stream from %s: %v", t.interfaceName(), err)
+			return
+		}
+
+		for {
+			data, ci, err := pcapReader.ReadPacketData()
+			if err == io.EOF || err == errCaptureStopped {
+				return
+			}
+			if err != nil {
+				log.Errorf("error reading packet from %s: %v", t.interfaceName(), err)
+				return
+			}
+
+			m.mu.Lock()
+			writeErr := m.writer.WritePacket(ci, data)
+			m.mu.Unlock()
+
+			if writeErr != nil {
+				log.Errorf("failed to write packet from %s: %v", t.interfaceName(), writeErr)
+				return
+			}
+		}
+	}()
+}
+
+func (m *mergedCapture) wait() {
+	m.wg.Wait()
+}
+
+func (m *mergedCapture) flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.writer.Flush()
+}
+
+// watchPodChurn starts and stops capture streams as pods matching the
+// selector come and go, adding a new IDB for every pod that appears after
+// the initial capture started. It runs until stopCh is closed.
+func (o *SniffOptions) watchPodChurn(capture *mergedCapture, known map[string]bool, stopCh <-chan struct{}) {
+	watcher, err := o.clientset.CoreV1().Pods(o.userSpecifiedNamespace).Watch(metav1.ListOptions{
+		LabelSelector: o.userSpecifiedSelector,
+	})
+	if err != nil {
+		log.Errorf("failed to watch pods for selector '%s': %v", o.userSpecifiedSelector, err)
+		return
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+
+			pod, isPod := event.Object.(*corev1.Pod)
+			if !isPod {
+				continue
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				if known[pod.Name] {
+					continue
+				}
+				if pod.Status.Phase != corev1.PodRunning {
+					continue
+				}
+
+				known[pod.Name] = true
+				target := podTarget{pod: pod.Name, container: o.containerForPod(pod), node: pod.Spec.NodeName}
+				log.Infof("selector matched new pod '%s', starting capture", pod.Name)
+				capture.capture(o, target)
+			case watch.Deleted:
+				delete(known, pod.Name)
+				log.Infof("pod '%s' deleted, stopping its capture", pod.Name)
+				capture.stop(pod.Name)
+			}
+		}
+	}
+}
+
+func (o *SniffOptions) RunMultiPod() error {
+	if o.userSpecifiedOutputFile == "" {
+		return errors.New("--selector capture requires --output_file, live Wireshark streaming isn't supported for multi-pod capture")
+	}
+
+	targets, err := o.resolvePodTargets()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(o.userSpecifiedOutputFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	capture, err := newMergedCapture(f)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool)
+	for _, target := range targets {
+		known[target.pod] = true
+		log.Infof("capturing from %s", target.interfaceName())
+		capture.capture(o, target)
+	}
+
+	stopCh := make(chan struct{})
+	go o.watchPodChurn(capture, known, stopCh)
+	defer close(stopCh)
+
+	capture.wait()
+
+	return capture.flush()
+}
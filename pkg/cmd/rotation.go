@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"ksniff/kube"
+)
+
+const rotationRemoteDir = "/tmp/ksniff-rotate"
+const rotationFilePattern = "ksniff-%Y%m%d-%H%M%S-%03d.pcap"
+const rotationPollInterval = 2 * time.Second
+
+func (o *SniffOptions) rotationEnabled() bool {
+	return o.userSpecifiedFileSizeMB > 0 || o.userSpecifiedRotateSeconds > 0
+}
+
+// buildRotatingTcpdumpCommand is the rotating counterpart of the plain
+// `-U -w -` invocation in ExecuteTcpdumpOnRemotePod: instead of streaming a
+// single unbounded pcap over stdout, tcpdump writes closed, timestamped
+// segments to disk so a long-running capture can't fill the container's
+// tmpfs or hand Wireshark a multi-GB file.
+func (o *SniffOptions) buildRotatingTcpdumpCommand() []string {
+	args := []string{o.userSpecifiedRemoteTcpdumpPath}
+
+	if o.userSpecifiedFileSizeMB > 0 {
+		args = append(args, "-C", strconv.Itoa(o.userSpecifiedFileSizeMB))
+	}
+
+	if o.userSpecifiedRotateSeconds > 0 {
+		args = append(args, "-G", strconv.Itoa(o.userSpecifiedRotateSeconds))
+	}
+
+	args = append(args, "-w", path.Join(rotationRemoteDir, rotationFilePattern))
+
+	if o.userSpecifiedFilter != "" {
+		args = append(args, o.userSpecifiedFilter)
+	}
+
+	shellCmd := fmt.Sprintf("mkdir -p %s && %s", rotationRemoteDir, strings.Join(args, " "))
+
+	return []string{"/bin/sh", "-c", shellCmd}
+}
+
+// byteCountWriter wraps a writer to track how many bytes have passed
+// through it, used to enforce --max-total-size across rotation segments.
+type byteCountWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *byteCountWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (o *SniffOptions) listClosedRotationSegments() ([]string, error) {
+	stdOut := new(kube.Writer)
+	stdErr := new(kube.Writer)
+
+	exitCode, err := o.runner.Run([]string{"/bin/sh", "-c", fmt.Sprintf("ls -1 %s 2>/dev/null", rotationRemoteDir)}, nil, stdOut, stdErr)
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 {
+		return nil, nil
+	}
+
+	segments := strings.Fields(stdOut.Output)
+	if len(segments) < 2 {
+		// The newest segment is still open for writing, so we never
+		// transfer it until a later poll sees a closed one after it.
+		return nil, nil
+	}
+
+	sort.Strings(segments)
+
+	return segments[:len(segments)-1], nil
+}
+
+func (o *SniffOptions) fetchAndDeleteRotationSegment(name string, outputWriter io.Writer) (int64, error) {
+	remotePath := path.Join(rotationRemoteDir, name)
+
+	counter := &byteCountWriter{w: outputWriter}
+	stdErr := new(kube.Writer)
+
+	exitCode, err := o.runner.Run([]string{"cat", remotePath}, nil, counter, stdErr)
+	if err != nil {
+		return 0, err
+	}
+
+	if exitCode != 0 {
+		return 0, errors.Errorf("failed to transfer segment '%s': %s", remotePath, stdErr.Output)
+	}
+
+	if _, err := o.runner.Run([]string{"rm", "-f", remotePath}, nil, new(kube.NopWriter), new(kube.NopWriter)); err != nil {
+		log.Warnf("failed to delete transferred segment '%s': %v", remotePath, err)
+	}
+
+	return counter.n, nil
+}
+
+// errMaxTotalSizeReached signals that tailRotationDir stopped because
+// --max-total-size was hit, as opposed to an I/O error, so the caller knows
+// it also needs to stop the remote tcpdump process rather than just the
+// local polling loop.
+var errMaxTotalSizeReached = errors.New("reached --max-total-size")
+
+func (o *SniffOptions) tailRotationDir(outputWriter io.Writer) error {
+	transferred := make(map[string]bool)
+
+	var totalBytes int64
+
+	maxTotalBytes := int64(o.userSpecifiedMaxTotalSizeMB) * 1024 * 1024
+
+	for {
+		time.Sleep(rotationPollInterval)
+
+		segments, err := o.listClosedRotationSegments()
+		if err != nil {
+			log.Errorf("failed to list rotation segments: %v", err)
+			continue
+		}
+
+		for _, segment := range segments {
+			if transferred[segment] {
+				continue
+			}
+
+			n, err := o.fetchAndDeleteRotationSegment(segment, outputWriter)
+			if err != nil {
+				log.Errorf("failed to transfer rotation segment '%s': %v", segment, err)
+				continue
+			}
+
+			transferred[segment] = true
+			totalBytes += n
+
+			log.Infof("transferred rotation segment '%s' (%d bytes, %d total)", segment, n, totalBytes)
+
+			if maxTotalBytes > 0 && totalBytes >= maxTotalBytes {
+				log.Infof("reached --max-total-size (%dMiB), stopping capture", o.userSpecifiedMaxTotalSizeMB)
+				return errMaxTotalSizeReached
+			}
+		}
+	}
+}
+
+// killRemoteRotatingTcpdump stops the background rotating tcpdump started
+// by RunWithRotation. It's the only way to signal it, since Runner.Run is a
+// blocking call with no cancellation hook of its own.
+func (o *SniffOptions) killRemoteRotatingTcpdump() {
+	log.Infof("stopping remote rotating tcpdump on pod '%s'", o.userSpecifiedPod)
+
+	stdOut := new(kube.NopWriter)
+	stdErr := new(kube.Writer)
+
+	killCmd := []string{"/bin/sh", "-c", fmt.Sprintf("pkill -f %s", o.userSpecifiedRemoteTcpdumpPath)}
+
+	if _, err := o.runner.Run(killCmd, nil, stdOut, stdErr); err != nil {
+		log.Warnf("failed to stop remote rotating tcpdump: %v", err)
+	}
+}
+
+// tailRotationDirUntilDone runs tailRotationDir to completion and, if it
+// stopped because --max-total-size was reached, kills the remote tcpdump
+// process so it stops writing segments into the container's tmpfs.
+func (o *SniffOptions) tailRotationDirUntilDone(outputWriter io.Writer) error {
+	err := o.tailRotationDir(outputWriter)
+	if err == errMaxTotalSizeReached {
+		o.killRemoteRotatingTcpdump()
+		return nil
+	}
+
+	return err
+}
+
+func mkTempFifo() (string, error) {
+	dir, err := ioutil.TempDir("", "ksniff-fifo")
+	if err != nil {
+		return "", err
+	}
+
+	fifoPath := path.Join(dir, "ksniff.fifo")
+	if err := syscall.Mkfifo(fifoPath, 0600); err != nil {
+		return "", err
+	}
+
+	return fifoPath, nil
+}
+
+// installRotationInterruptHandler stops the remote rotating tcpdump on
+// Ctrl-C/SIGTERM. Without it, interrupting a --file-size/--rotate-seconds
+// capture leaves the remote tcpdump running and writing segments into
+// rotationRemoteDir forever, the same tmpfs-overflow problem rotation exists
+// to prevent, just reached via the interrupt path instead of a missed
+// --max-total-size check.
+func (o *SniffOptions) installRotationInterruptHandler() func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-sigCh:
+			log.Info("received interrupt, stopping remote rotating tcpdump")
+			o.killRemoteRotatingTcpdump()
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
+
+// RunWithRotation is the --file-size/--rotate-seconds counterpart of Run's
+// plain streaming path: it starts a rotating tcpdump on the remote side and
+// tails the rotation directory, stitching closed segments back into either
+// --output_file or a fifo fed sequentially into Wireshark.
+func (o *SniffOptions) RunWithRotation() error {
+	log.Infof("starting rotating capture on pod '%s' [file-size: %dMiB, rotate-seconds: %ds, max-total-size: %dMiB]",
+		o.userSpecifiedPod, o.userSpecifiedFileSizeMB, o.userSpecifiedRotateSeconds, o.userSpecifiedMaxTotalSizeMB)
+
+	defer o.installRotationInterruptHandler()()
+
+	go func() {
+		stdOut := new(kube.NopWriter)
+		stdErr := new(kube.NopWriter)
+
+		if _, err := o.runner.Run(o.buildRotatingTcpdumpCommand(), nil, stdOut, stdErr); err != nil {
+			log.Errorf("rotating tcpdump exited: %v", err)
+		}
+	}()
+
+	if o.userSpecifiedOutputFile != "" {
+		f, err := os.Create(o.userSpecifiedOutputFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return o.tailRotationDirUntilDone(f)
+	}
+
+	fifoPath, err := mkTempFifo()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(path.Dir(fifoPath))
+
+	wiresharkCmd := exec.Command("wireshark", "-k", "-i", fifoPath)
+	if err := wiresharkCmd.Start(); err != nil {
+		return err
+	}
+
+	fifo, err := os.OpenFile(fifoPath, os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		return err
+	}
+	defer fifo.Close()
+
+	go o.tailRotationDirUntilDone(fifo)
+
+	return wiresharkCmd.Wait()
+}
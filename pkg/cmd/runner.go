@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	KubeRunnerType = "kube"
+	NodeRunnerType = "node"
+	HostRunnerType = "host"
+)
+
+// Runner abstracts the mechanics of reaching the target pod's network
+// namespace so ExecuteTcpdumpOnRemotePod doesn't need to know whether it's
+// talking to the pod's own container, a privileged helper pod on the same
+// node, or the node itself over SSH. Every backend is expected to implement
+// this the same way minikube's driver abstraction wraps exec.Cmd: explicit
+// stdin/stdout/stderr instead of string-formatted shell commands.
+type Runner interface {
+	// Run executes cmd, streaming stdin to the process and its stdout/stderr
+	// to the given writers, and returns the process exit code.
+	Run(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+
+	// CopyFile copies the local file at src to dst on the runner's target.
+	CopyFile(src, dst string) error
+
+	// Close releases any resources the runner created to reach its target,
+	// e.g. a helper pod scheduled onto the node. It's a no-op for runners
+	// that don't provision anything of their own.
+	Close() error
+}
+
+// NewRunner builds the Runner selected by the user via --runner.
+func NewRunner(runnerType string, o *SniffOptions) (Runner, error) {
+	switch runnerType {
+	case KubeRunnerType:
+		return NewKubeRunner(o), nil
+	case NodeRunnerType:
+		return NewNodeRunner(o), nil
+	case HostRunnerType:
+		return NewHostRunner(o), nil
+	default:
+		return nil, errors.Errorf("unsupported runner type: '%s', expected one of: %s, %s, %s",
+			runnerType, KubeRunnerType, NodeRunnerType, HostRunnerType)
+	}
+}
+
+// installRunnerCleanup makes sure o.runner.Close() runs both on normal
+// return and on Ctrl-C, so a runner that provisioned something (e.g.
+// NodeRunner's helper pod) doesn't leak just because the capture was
+// interrupted rather than left to finish on its own.
+func (o *SniffOptions) installRunnerCleanup() func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-sigCh:
+			log.Info("received interrupt, cleaning up runner resources")
+			if err := o.runner.Close(); err != nil {
+				log.Errorf("failed to clean up runner: %v", err)
+			}
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+
+		if err := o.runner.Close(); err != nil {
+			log.Errorf("failed to clean up runner: %v", err)
+		}
+	}
+}
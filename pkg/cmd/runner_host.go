@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HostRunner reaches the node directly over SSH instead of going through
+// the Kubernetes API. It's the most invasive backend (it requires SSH
+// access to the node) but works even when the API server can't schedule a
+// helper pod onto the node, e.g. on cordoned or otherwise unschedulable
+// nodes. Like NodeRunner, it resolves the target container's PID via
+// crictl and nsenters into its network namespace rather than capturing
+// whatever interface happens to be default on the node.
+type HostRunner struct {
+	options   *SniffOptions
+	nodeAddr  string
+	targetPid string
+}
+
+func NewHostRunner(o *SniffOptions) *HostRunner {
+	return &HostRunner{options: o}
+}
+
+func (r *HostRunner) resolveNodeAddr() (string, error) {
+	if r.nodeAddr != "" {
+		return r.nodeAddr, nil
+	}
+
+	pod, err := r.options.clientset.CoreV1().Pods(r.options.userSpecifiedNamespace).
+		Get(r.options.userSpecifiedPod, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	node, err := r.options.clientset.CoreV1().Nodes().Get(pod.Spec.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeExternalIP || addr.Type == corev1.NodeInternalIP {
+			r.nodeAddr = addr.Address
+			break
+		}
+	}
+
+	if r.nodeAddr == "" {
+		return "", errors.Errorf("could not resolve a reachable address for node '%s'", pod.Spec.NodeName)
+	}
+
+	return r.nodeAddr, nil
+}
+
+func (r *HostRunner) sshArgs(remoteCmd []string) ([]string, error) {
+	addr, err := r.resolveNodeAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-o", "StrictHostKeyChecking=accept-new"}
+
+	if r.options.userSpecifiedSSHUser != "" {
+		addr = r.options.userSpecifiedSSHUser + "@" + addr
+	}
+
+	args = append(args, addr)
+
+	// ssh joins its arguments with spaces and hands the result to the
+	// remote shell, so each one is single-quoted to keep cmd's argv intact.
+	for _, arg := range remoteCmd {
+		args = append(args, "'"+strings.Replace(arg, "'", `'\''`, -1)+"'")
+	}
+
+	return args, nil
+}
+
+// runOnNode executes cmd on the node itself, with no network namespace
+// scoping. It's used both for the user-requested capture command (wrapped
+// in nsenter by Run) and for the crictl lookups Run needs to find the
+// right namespace to enter.
+func (r *HostRunner) runOnNode(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	args, err := r.sshArgs(cmd)
+	if err != nil {
+		return -1, err
+	}
+
+	sshCmd := exec.Command("ssh", args...)
+	sshCmd.Stdin = stdin
+	sshCmd.Stdout = stdout
+	sshCmd.Stderr = stderr
+
+	runErr := sshCmd.Run()
+
+	exitCode := 0
+	if exitErr, ok := runErr.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	} else if runErr != nil {
+		return -1, errors.Wrap(runErr, "failed to run command over ssh")
+	}
+
+	return exitCode, nil
+}
+
+// resolveContainerID looks up the CRI container ID for the target
+// container via `crictl ps` on the node, narrowed to the exact pod by its
+// namespace/name labels since crictl has no "pod name" argument of its own.
+func (r *HostRunner) resolveContainerID() (string, error) {
+	container := r.options.userSpecifiedContainer
+	if container == "" {
+		return "", errors.New("no container specified to resolve via crictl")
+	}
+
+	var stdOut, stdErr bytes.Buffer
+
+	psCmd := []string{"crictl", "ps", "--name", container, "--state", "Running", "-o", "json"}
+
+	exitCode, err := r.runOnNode(psCmd, nil, &stdOut, &stdErr)
+	if err != nil {
+		return "", err
+	}
+
+	if exitCode != 0 {
+		return "", errors.Errorf("crictl ps failed: %s", stdErr.String())
+	}
+
+	var list struct {
+		Containers []struct {
+			ID     string            `json:"id"`
+			Labels map[string]string `json:"labels"`
+		} `json:"containers"`
+	}
+
+	if err := json.Unmarshal(stdOut.Bytes(), &list); err != nil {
+		return "", errors.Wrap(err, "failed to parse crictl ps output")
+	}
+
+	for _, c := range list.Containers {
+		if c.Labels["io.kubernetes.pod.name"] == r.options.userSpecifiedPod &&
+			c.Labels["io.kubernetes.pod.namespace"] == r.options.userSpecifiedNamespace {
+			return c.ID, nil
+		}
+	}
+
+	return "", errors.Errorf("no running container named '%s' found for pod '%s/%s'",
+		container, r.options.userSpecifiedNamespace, r.options.userSpecifiedPod)
+}
+
+func (r *HostRunner) resolveTargetPid() (string, error) {
+	if r.targetPid != "" {
+		return r.targetPid, nil
+	}
+
+	containerID, err := r.resolveContainerID()
+	if err != nil {
+		return "", err
+	}
+
+	var stdOut, stdErr bytes.Buffer
+
+	inspectCmd := []string{"crictl", "inspect", "--output", "go-template", "--template", "{{.info.pid}}", containerID}
+
+	exitCode, err := r.runOnNode(inspectCmd, nil, &stdOut, &stdErr)
+	if err != nil {
+		return "", err
+	}
+
+	if exitCode != 0 || stdOut.Len() == 0 {
+		return "", errors.Errorf("failed to resolve container pid via crictl: %s", stdErr.String())
+	}
+
+	r.targetPid = strings.TrimSpace(stdOut.String())
+
+	return r.targetPid, nil
+}
+
+func (r *HostRunner) Run(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	pid, err := r.resolveTargetPid()
+	if err != nil {
+		return -1, err
+	}
+
+	nsenterCmd := append([]string{"nsenter", "-t", pid, "-n"}, cmd...)
+
+	return r.runOnNode(nsenterCmd, stdin, stdout, stderr)
+}
+
+func (r *HostRunner) CopyFile(src, dst string) error {
+	addr, err := r.resolveNodeAddr()
+	if err != nil {
+		return err
+	}
+
+	if r.options.userSpecifiedSSHUser != "" {
+		addr = r.options.userSpecifiedSSHUser + "@" + addr
+	}
+
+	scpCmd := exec.Command("scp", "-o", "StrictHostKeyChecking=accept-new", src, addr+":"+dst)
+
+	var stderr bytes.Buffer
+	scpCmd.Stderr = &stderr
+
+	if err := scpCmd.Run(); err != nil {
+		return errors.Wrapf(err, "scp failed: %s", stderr.String())
+	}
+
+	return nil
+}
+
+// Close is a no-op: HostRunner doesn't provision anything of its own, it
+// only opens a transient SSH connection per command.
+func (r *HostRunner) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"ksniff/kube"
+)
+
+// KubeRunner is the original backend: it runs commands inside the target
+// container itself via `kubectl exec` and copies files in over the same
+// exec-based transport. It requires the container to have a shell and a
+// writable filesystem, which distroless/scratch images don't provide.
+type KubeRunner struct {
+	options *SniffOptions
+}
+
+func NewKubeRunner(o *SniffOptions) *KubeRunner {
+	return &KubeRunner{options: o}
+}
+
+func (r *KubeRunner) Run(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	req := kube.ExecCommandRequest{
+		KubeRequest: kube.KubeRequest{
+			Clientset:  r.options.clientset,
+			RestConfig: r.options.restConfig,
+			Namespace:  r.options.userSpecifiedNamespace,
+			Pod:        r.options.userSpecifiedPod,
+			Container:  r.options.userSpecifiedContainer,
+		},
+		Command: cmd,
+		StdIn:   stdin,
+		StdOut:  stdout,
+		StdErr:  stderr,
+	}
+
+	return kube.PodExecuteCommand(req)
+}
+
+func (r *KubeRunner) CopyFile(src, dst string) error {
+	req := kube.UploadFileRequest{
+		KubeRequest: kube.KubeRequest{
+			Clientset:  r.options.clientset,
+			RestConfig: r.options.restConfig,
+			Namespace:  r.options.userSpecifiedNamespace,
+			Pod:        r.options.userSpecifiedPod,
+			Container:  r.options.userSpecifiedContainer,
+		},
+		Src: src,
+		Dst: dst,
+	}
+
+	exitCode, err := kube.PodUploadFile(req)
+	if err != nil || exitCode != 0 {
+		return errors.Wrapf(err, "upload file command failed, exitCode: %d", exitCode)
+	}
+
+	return nil
+}
+
+// Close is a no-op: KubeRunner doesn't provision anything of its own, it
+// only talks to the container the user already asked to capture on.
+func (r *KubeRunner) Close() error {
+	return nil
+}
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"ksniff/kube"
+)
+
+const (
+	nodeRunnerPodNamePrefix = "ksniff-node-runner-"
+	nodeRunnerImage         = "docker.io/ksniff/node-runner:latest"
+	nodeRunnerCriSocket     = "/run/containerd/containerd.sock"
+)
+
+// NodeRunner schedules a short-lived, privileged helper pod on the same node
+// as the target pod and runs commands inside it with nsenter, entering the
+// target container's network namespace via the PID crictl reports for it.
+// This sidesteps the target container entirely, so it works against
+// distroless/scratch images that have no shell of their own.
+type NodeRunner struct {
+	options   *SniffOptions
+	helperPod *corev1.Pod
+	targetPid string
+	nodeName  string
+	criSocket string
+}
+
+func NewNodeRunner(o *SniffOptions) *NodeRunner {
+	return &NodeRunner{
+		options:   o,
+		criSocket: nodeRunnerCriSocket,
+	}
+}
+
+func (r *NodeRunner) ensureHelperPod() error {
+	if r.helperPod != nil {
+		return nil
+	}
+
+	pod, err := r.options.clientset.CoreV1().Pods(r.options.userSpecifiedNamespace).
+		Get(r.options.userSpecifiedPod, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	r.nodeName = pod.Spec.NodeName
+	if r.nodeName == "" {
+		return errors.New("target pod isn't scheduled to a node yet")
+	}
+
+	privileged := true
+	hostPathSocket := corev1.HostPathSocket
+
+	helperPodSpec := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: nodeRunnerPodNamePrefix,
+			Namespace:    r.options.userSpecifiedNamespace,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:      r.nodeName,
+			HostPID:       true,
+			HostNetwork:   false,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:    "ksniff-node-runner",
+					Image:   nodeRunnerImage,
+					Command: []string{"sleep", "infinity"},
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: &privileged,
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "cri-socket", MountPath: r.criSocket},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "cri-socket",
+					VolumeSource: corev1.VolumeSource{
+						HostPath: &corev1.HostPathVolumeSource{
+							Path: r.criSocket,
+							Type: &hostPathSocket,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	createdPod, err := r.options.clientset.CoreV1().Pods(r.options.userSpecifiedNamespace).Create(helperPodSpec)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule node runner helper pod")
+	}
+
+	r.helperPod = createdPod
+
+	log.Infof("waiting for node runner helper pod '%s' to become ready on node '%s'", createdPod.Name, r.nodeName)
+
+	return kube.WaitForPodRunning(r.options.clientset, r.options.userSpecifiedNamespace, createdPod.Name, 60*time.Second)
+}
+
+// resolveContainerID looks up the CRI container ID for the target
+// container via `crictl ps`, filtered by container name and then narrowed
+// to the exact pod by its namespace/name labels, since crictl has no
+// "pod name" argument of its own and container names aren't unique across
+// pods.
+func (r *NodeRunner) resolveContainerID() (string, error) {
+	container := r.options.userSpecifiedContainer
+	if container == "" {
+		return "", errors.New("no container specified to resolve via crictl")
+	}
+
+	psCmd := []string{"crictl", "--runtime-endpoint", "unix://" + r.criSocket,
+		"ps", "--name", container, "--state", "Running", "-o", "json"}
+
+	stdOut := new(kube.Writer)
+	stdErr := new(kube.Writer)
+
+	exitCode, err := r.runInHelper(psCmd, nil, stdOut, stdErr)
+	if err != nil {
+		return "", err
+	}
+
+	if exitCode != 0 {
+		return "", errors.Errorf("crictl ps failed: %s", stdErr.Output)
+	}
+
+	var list struct {
+		Containers []struct {
+			ID     string            `json:"id"`
+			Labels map[string]string `json:"labels"`
+		} `json:"containers"`
+	}
+
+	if err := json.Unmarshal([]byte(stdOut.Output), &list); err != nil {
+		return "", errors.Wrap(err, "failed to parse crictl ps output")
+	}
+
+	for _, c := range list.Containers {
+		if c.Labels["io.kubernetes.pod.name"] == r.options.userSpecifiedPod &&
+			c.Labels["io.kubernetes.pod.namespace"] == r.options.userSpecifiedNamespace {
+			return c.ID, nil
+		}
+	}
+
+	return "", errors.Errorf("no running container named '%s' found for pod '%s/%s'",
+		container, r.options.userSpecifiedNamespace, r.options.userSpecifiedPod)
+}
+
+func (r *NodeRunner) resolveTargetPid() (string, error) {
+	if r.targetPid != "" {
+		return r.targetPid, nil
+	}
+
+	containerID, err := r.resolveContainerID()
+	if err != nil {
+		return "", err
+	}
+
+	crictlCmd := []string{"crictl", "--runtime-endpoint", "unix://" + r.criSocket,
+		"inspect", "--output", "go-template", "--template", "{{.info.pid}}",
+		containerID}
+
+	stdOut := new(kube.Writer)
+	stdErr := new(kube.Writer)
+
+	exitCode, err := r.runInHelper(crictlCmd, nil, stdOut, stdErr)
+	if err != nil {
+		return "", err
+	}
+
+	if exitCode != 0 || stdOut.Output == "" {
+		return "", errors.Errorf("failed to resolve container pid via crictl: %s", stdErr.Output)
+	}
+
+	r.targetPid = stdOut.Output
+
+	return r.targetPid, nil
+}
+
+func (r *NodeRunner) runInHelper(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	req := kube.ExecCommandRequest{
+		KubeRequest: kube.KubeRequest{
+			Clientset:  r.options.clientset,
+			RestConfig: r.options.restConfig,
+			Namespace:  r.helperPod.Namespace,
+			Pod:        r.helperPod.Name,
+			Container:  "ksniff-node-runner",
+		},
+		Command: cmd,
+		StdIn:   stdin,
+		StdOut:  stdout,
+		StdErr:  stderr,
+	}
+
+	return kube.PodExecuteCommand(req)
+}
+
+func (r *NodeRunner) Run(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if err := r.ensureHelperPod(); err != nil {
+		return -1, err
+	}
+
+	pid, err := r.resolveTargetPid()
+	if err != nil {
+		return -1, err
+	}
+
+	nsenterCmd := append([]string{"nsenter", "-t", pid, "-n"}, cmd...)
+
+	return r.runInHelper(nsenterCmd, stdin, stdout, stderr)
+}
+
+func (r *NodeRunner) CopyFile(src, dst string) error {
+	if err := r.ensureHelperPod(); err != nil {
+		return err
+	}
+
+	req := kube.UploadFileRequest{
+		KubeRequest: kube.KubeRequest{
+			Clientset:  r.options.clientset,
+			RestConfig: r.options.restConfig,
+			Namespace:  r.helperPod.Namespace,
+			Pod:        r.helperPod.Name,
+			Container:  "ksniff-node-runner",
+		},
+		Src: src,
+		Dst: dst,
+	}
+
+	exitCode, err := kube.PodUploadFile(req)
+	if err != nil || exitCode != 0 {
+		return errors.Wrapf(err, "upload file to node runner helper pod failed, exitCode: %d", exitCode)
+	}
+
+	return nil
+}
+
+// Close deletes the privileged helper pod this runner scheduled, if any.
+// Without this every --runner node invocation would leak a privileged,
+// host-PID pod on the node.
+func (r *NodeRunner) Close() error {
+	if r.helperPod == nil {
+		return nil
+	}
+
+	log.Infof("deleting node runner helper pod '%s'", r.helperPod.Name)
+
+	err := r.options.clientset.CoreV1().Pods(r.helperPod.Namespace).Delete(r.helperPod.Name, &metav1.DeleteOptions{})
+	r.helperPod = nil
+
+	if err != nil {
+		return errors.Wrap(err, "failed to delete node runner helper pod")
+	}
+
+	return nil
+}
@@ -25,6 +25,11 @@ var (
 const tcpdumpLocalPath = "/tcpdump-static"
 const tcpdumpRemotePath = "/tmp/static-tcpdump"
 
+const (
+	BackendTcpdump = "tcpdump"
+	BackendEbpf    = "ebpf"
+)
+
 type SniffOptions struct {
 	configFlags                    *genericclioptions.ConfigFlags
 	resultingContext               *api.Context
@@ -35,9 +40,25 @@ type SniffOptions struct {
 	userSpecifiedOutputFile        string
 	userSpecifiedLocalTcpdumpPath  string
 	userSpecifiedRemoteTcpdumpPath string
+	userSpecifiedRunnerType        string
+	userSpecifiedSSHUser           string
+	userSpecifiedSelector          string
+	extcapInterfaces               bool
+	extcapDlts                     bool
+	extcapConfig                   bool
+	extcapCapture                  bool
+	extcapInterface                string
+	extcapFifo                     string
+	userSpecifiedBackend           string
+	userSpecifiedLocalEbpfPath     string
+	activeBackend                  string
+	userSpecifiedFileSizeMB        int
+	userSpecifiedRotateSeconds     int
+	userSpecifiedMaxTotalSizeMB    int
 	clientset                      *kubernetes.Clientset
 	restConfig                     *rest.Config
 	rawConfig                      api.Config
+	runner                         Runner
 	genericclioptions.IOStreams
 }
 
@@ -58,6 +79,10 @@ func NewCmdSniff(streams genericclioptions.IOStreams) *cobra.Command {
 		Example:      ksniffExample,
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
+			if o.IsExtcapMode() {
+				return o.RunExtcap(c)
+			}
+
 			if err := o.Complete(c, args); err != nil {
 				return err
 			}
@@ -78,19 +103,44 @@ func NewCmdSniff(streams genericclioptions.IOStreams) *cobra.Command {
 	cmd.Flags().StringVarP(&o.userSpecifiedOutputFile, "output_file", "o", "", "output file path, tcpdump output will be redirect to this file instead of wireshark (optional)")
 	cmd.Flags().StringVarP(&o.userSpecifiedLocalTcpdumpPath, "local_tcpdump_path", "l", tcpdumpLocalPath, "local static tcpdump binary path (optional)")
 	cmd.Flags().StringVarP(&o.userSpecifiedRemoteTcpdumpPath, "remote_tcpdump_path", "r", tcpdumpRemotePath, "remote static tcpdump binary path (optional)")
+	cmd.Flags().StringVar(&o.userSpecifiedRunnerType, "runner", KubeRunnerType,
+		fmt.Sprintf("capture backend to use, one of: %s, %s, %s (optional)", KubeRunnerType, NodeRunnerType, HostRunnerType))
+	cmd.Flags().StringVar(&o.userSpecifiedSSHUser, "ssh-user", "", "user to use when connecting to the node over ssh, only used by the host runner (optional)")
+	cmd.Flags().StringVar(&o.userSpecifiedSelector, "selector", "", "label selector to capture from every matching pod instead of a single named pod, mutually exclusive with the pod argument (optional)")
+
+	cmd.Flags().BoolVar(&o.extcapInterfaces, "extcap-interfaces", false, "wireshark extcap: list available capture interfaces")
+	cmd.Flags().BoolVar(&o.extcapDlts, "extcap-dlts", false, "wireshark extcap: list data link types for --extcap-interface")
+	cmd.Flags().BoolVar(&o.extcapConfig, "extcap-config", false, "wireshark extcap: list configurable arguments for --extcap-interface")
+	cmd.Flags().BoolVar(&o.extcapCapture, "capture", false, "wireshark extcap: start capturing on --extcap-interface into --fifo")
+	cmd.Flags().StringVar(&o.extcapInterface, "extcap-interface", "", "wireshark extcap: interface to operate on, formatted as ksniff:<namespace>/<pod>/<container>")
+	cmd.Flags().StringVar(&o.extcapFifo, "fifo", "", "wireshark extcap: fifo to stream captured packets into")
+	cmd.Flags().String("extcap-version", "", "wireshark extcap: caller's extcap protocol version (ignored)")
+
+	cmd.Flags().StringVar(&o.userSpecifiedBackend, "backend", BackendTcpdump,
+		fmt.Sprintf("capture backend to use, one of: %s, %s; falls back to %s if the target doesn't support eBPF (optional)",
+			BackendTcpdump, BackendEbpf, BackendTcpdump))
+	cmd.Flags().StringVar(&o.userSpecifiedLocalEbpfPath, "local_ebpf_path", ebpfLocalPath, "local ksniff eBPF loader binary path, only used by the ebpf backend (optional)")
+
+	cmd.Flags().IntVarP(&o.userSpecifiedFileSizeMB, "file-size", "C", 0, "rotate the remote capture file every N MiB instead of streaming a single unbounded pcap (optional)")
+	cmd.Flags().IntVarP(&o.userSpecifiedRotateSeconds, "rotate-seconds", "G", 0, "rotate the remote capture file every N seconds instead of streaming a single unbounded pcap (optional)")
+	cmd.Flags().IntVar(&o.userSpecifiedMaxTotalSizeMB, "max-total-size", 0, "stop capturing once the cumulative size of transferred pcap segments exceeds N MiB, only used with --file-size/--rotate-seconds (optional)")
 
 	return cmd
 }
 
 func (o *SniffOptions) Complete(cmd *cobra.Command, args []string) error {
 
-	if len(args) < 1 {
+	if o.userSpecifiedSelector != "" {
+		if len(args) > 0 {
+			return errors.New("cannot specify both a pod name and --selector")
+		}
+	} else if len(args) < 1 {
 		cmd.Usage()
 		return errors.Errorf("not enough arguments (%d)", len(args))
+	} else {
+		o.userSpecifiedPod = args[0]
 	}
 
-	o.userSpecifiedPod = args[0]
-
 	var err error
 
 	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
@@ -118,6 +168,13 @@ func (o *SniffOptions) Complete(cmd *cobra.Command, args []string) error {
 	o.resultingContext = currentContext.DeepCopy()
 	o.resultingContext.Namespace = o.userSpecifiedNamespace
 
+	if o.userSpecifiedSelector == "" {
+		o.runner, err = NewRunner(o.userSpecifiedRunnerType, o)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -130,6 +187,16 @@ func (o *SniffOptions) Validate() error {
 		return errors.New("namespace value is empty should be custom or default")
 	}
 
+	if o.rotationEnabled() && o.userSpecifiedBackend == BackendEbpf {
+		return errors.New("--file-size/--rotate-seconds rotation is not supported with --backend ebpf, " +
+			"the eBPF loader doesn't understand tcpdump's rotation flags")
+	}
+
+	if o.userSpecifiedSelector != "" {
+		_, err := o.resolvePodTargets()
+		return err
+	}
+
 	pod, err := o.clientset.CoreV1().Pods(o.userSpecifiedNamespace).Get(o.userSpecifiedPod, v1.GetOptions{})
 	if err != nil {
 		return err
@@ -156,20 +223,7 @@ func CheckIfTcpdumpExistOnPod(o *SniffOptions, tcpdumpRemotePath string) (bool,
 	stdOut := new(kube.Writer)
 	stdErr := new(kube.Writer)
 
-	req := kube.ExecCommandRequest{
-		KubeRequest: kube.KubeRequest{
-			Clientset:  o.clientset,
-			RestConfig: o.restConfig,
-			Namespace:  o.userSpecifiedNamespace,
-			Pod:        o.userSpecifiedPod,
-			Container:  o.userSpecifiedContainer,
-		},
-		Command: []string{"/bin/sh", "-c", fmt.Sprintf("ls -alt %s", tcpdumpRemotePath)},
-		StdOut:  stdOut,
-		StdErr:  stdErr,
-	}
-
-	exitCode, err := kube.PodExecuteCommand(req)
+	exitCode, err := o.runner.Run([]string{"/bin/sh", "-c", fmt.Sprintf("ls -alt %s", tcpdumpRemotePath)}, nil, stdOut, stdErr)
 	if err != nil {
 		return false, err
 	}
@@ -188,6 +242,12 @@ func CheckIfTcpdumpExistOnPod(o *SniffOptions, tcpdumpRemotePath string) (bool,
 }
 
 func (o *SniffOptions) UploadTcpdumpIfMissing() error {
+	o.activeBackend = o.selectBackend()
+
+	if o.activeBackend == BackendEbpf {
+		return o.uploadEbpfLoaderIfMissing()
+	}
+
 	log.Infof("checking for static tcpdump binary on: %s", o.userSpecifiedRemoteTcpdumpPath)
 
 	isExist, err := CheckIfTcpdumpExistOnPod(o, o.userSpecifiedRemoteTcpdumpPath)
@@ -202,21 +262,8 @@ func (o *SniffOptions) UploadTcpdumpIfMissing() error {
 
 	log.Infof("couldn't find static tcpdump binary on: %s, starting to upload", o.userSpecifiedRemoteTcpdumpPath)
 
-	req := kube.UploadFileRequest{
-		KubeRequest: kube.KubeRequest{
-			Clientset:  o.clientset,
-			RestConfig: o.restConfig,
-			Namespace:  o.userSpecifiedNamespace,
-			Pod:        o.userSpecifiedPod,
-			Container:  o.userSpecifiedContainer,
-		},
-		Src: o.userSpecifiedLocalTcpdumpPath,
-		Dst: o.userSpecifiedRemoteTcpdumpPath,
-	}
-
-	exitCode, err := kube.PodUploadFile(req)
-	if err != nil || exitCode != 0 {
-		return errors.Wrapf(err, "upload file command failed, exitCode: %d", exitCode)
+	if err := o.runner.CopyFile(o.userSpecifiedLocalTcpdumpPath, o.userSpecifiedRemoteTcpdumpPath); err != nil {
+		return err
 	}
 
 	log.Info("tcpdump uploaded successfully")
@@ -225,23 +272,19 @@ func (o *SniffOptions) UploadTcpdumpIfMissing() error {
 }
 
 func (o *SniffOptions) ExecuteTcpdumpOnRemotePod(stdOut io.Writer) {
+	if o.activeBackend == BackendEbpf {
+		o.executeEbpfOnRemotePod(stdOut)
+		return
+	}
 
 	stdErr := new(kube.NopWriter)
 
-	executeTcpdumpRequest := kube.ExecCommandRequest{
-		KubeRequest: kube.KubeRequest{
-			Clientset:  o.clientset,
-			RestConfig: o.restConfig,
-			Namespace:  o.userSpecifiedNamespace,
-			Pod:        o.userSpecifiedPod,
-			Container:  o.userSpecifiedContainer,
-		},
-		Command: []string{o.userSpecifiedRemoteTcpdumpPath, "-U", "-w", "-"},
-		StdErr:  stdErr,
-		StdOut:  stdOut,
+	cmd := []string{o.userSpecifiedRemoteTcpdumpPath, "-U", "-w", "-"}
+	if o.userSpecifiedFilter != "" {
+		cmd = append(cmd, o.userSpecifiedFilter)
 	}
 
-	kube.PodExecuteCommand(executeTcpdumpRequest)
+	o.runner.Run(cmd, nil, stdOut, stdErr)
 }
 
 func (o *SniffOptions) Run() error {
@@ -249,6 +292,12 @@ func (o *SniffOptions) Run() error {
 	log.Info("Starting ksniff")
 	defer log.Info("Ksniff done")
 
+	if o.userSpecifiedSelector != "" {
+		return o.RunMultiPod()
+	}
+
+	defer o.installRunnerCleanup()()
+
 	log.Infof("Sniffing on pod: '%s' [namespace: '%s', container: '%s', filter: '%s']",
 		o.userSpecifiedPod, o.userSpecifiedNamespace, o.userSpecifiedContainer, o.userSpecifiedFilter)
 
@@ -257,6 +306,10 @@ func (o *SniffOptions) Run() error {
 		return err
 	}
 
+	if o.rotationEnabled() {
+		return o.RunWithRotation()
+	}
+
 	var outputWriter io.Writer
 
 	if o.userSpecifiedOutputFile != "" {
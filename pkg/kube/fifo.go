@@ -0,0 +1,9 @@
+package kube
+
+import "os"
+
+// OpenFifoForWrite opens a named pipe for writing, used to stream captured
+// packets into the FIFO Wireshark already created for an extcap capture.
+func OpenFifoForWrite(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY, os.ModeNamedPipe)
+}
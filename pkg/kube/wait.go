@@ -0,0 +1,29 @@
+package kube
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForPodRunning blocks until the named pod reaches the Running phase or
+// timeout elapses. Used after scheduling a helper pod to make sure it's
+// actually ready before exec'ing into it.
+func WaitForPodRunning(clientset *kubernetes.Clientset, namespace, name string, timeout time.Duration) error {
+	return wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if pod.Status.Phase == corev1.PodFailed {
+			return false, errors.Errorf("pod '%s' failed to start", name)
+		}
+
+		return pod.Status.Phase == corev1.PodRunning, nil
+	})
+}